@@ -9,11 +9,10 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"embed"
+	"encoding/pem"
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"sync"
 	"time"
 
@@ -21,7 +20,7 @@ import (
 	cfsslLog "github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/signer"
 	"github.com/cloudflare/cfssl/signer/local"
-	"go.keploy.io/server/v2/pkg/core/proxy/util"
+	"go.keploy.io/server/v2/pkg/core/proxy/tls/trustinstaller"
 	"go.keploy.io/server/v2/utils"
 	"go.uber.org/zap"
 )
@@ -35,66 +34,8 @@ var caPKey []byte //private key
 //go:embed asset
 var _ embed.FS
 
-var caStorePath = []string{
-	"/usr/local/share/ca-certificates/",
-	"/etc/pki/ca-trust/source/anchors/",
-	"/etc/ca-certificates/trust-source/anchors/",
-	"/etc/pki/trust/anchors/",
-	"/usr/local/share/certs/",
-	"/etc/ssl/certs/",
-}
-
-var caStoreUpdateCmd = []string{
-	"update-ca-certificates",
-	"update-ca-trust",
-	"trust extract-compat",
-	"tools-ca-trust extract",
-	"certctl rehash",
-}
-
-func commandExists(cmd string) bool {
-	_, err := exec.LookPath(cmd)
-	return err == nil
-}
-
-func updateCaStore(ctx context.Context) error {
-	commandRun := false
-	for _, cmd := range caStoreUpdateCmd {
-		if commandExists(cmd) {
-			commandRun = true
-			c := exec.CommandContext(ctx, cmd)
-			_, err := c.CombinedOutput()
-			if err != nil {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-					return err
-				}
-			}
-		}
-	}
-	if !commandRun {
-		return fmt.Errorf("no valid CA store tools command found")
-	}
-	return nil
-}
-
-func getCaPaths() ([]string, error) {
-	var caPaths []string
-	for _, dir := range caStorePath {
-		if util.IsDirectoryExist(dir) {
-			caPaths = append(caPaths, dir)
-		}
-	}
-	if len(caPaths) == 0 {
-		return nil, fmt.Errorf("no valid CA store path found")
-	}
-	return caPaths, nil
-}
-
 // to extract ca certificate to temp
-func extractCertToTemp() (string, error) {
+func extractCertToTemp(certPEM []byte) (string, error) {
 	tempFile, err := os.CreateTemp("", "ca.crt")
 
 	if err != nil {
@@ -114,7 +55,7 @@ func extractCertToTemp() (string, error) {
 	}
 
 	// Write to the file
-	_, err = tempFile.Write(caCrt)
+	_, err = tempFile.Write(certPEM)
 	if err != nil {
 		return "", err
 	}
@@ -127,127 +68,104 @@ func extractCertToTemp() (string, error) {
 	return tempFile.Name(), nil
 }
 
-// isJavaCAExist checks if the CA is already installed in the specified Java keystore
-func isJavaCAExist(ctx context.Context, alias, storepass, cacertsPath string) bool {
-	cmd := exec.CommandContext(ctx, "keytool", "-list", "-keystore", cacertsPath, "-storepass", storepass, "-alias", alias)
-
-	err := cmd.Run()
-	select {
-	case <-ctx.Done():
-		return false
-	default:
-	}
-	return err == nil
-}
-
-// installJavaCA installs the CA in the Java keystore
-func installJavaCA(ctx context.Context, logger *zap.Logger, caPath string) error {
-	// check if java is installed
-	if util.IsJavaInstalled() {
-		logger.Debug("checking java path from default java home")
-		javaHome, err := util.GetJavaHome(ctx)
-
-		if err != nil {
-			utils.LogError(logger, err, "Java detected but failed to find JAVA_HOME")
-			return err
-		}
-
-		// Assuming modern Java structure (without /jre/)
-		cacertsPath := fmt.Sprintf("%s/lib/security/cacerts", javaHome)
-		// You can modify these as per your requirements
-		storePass := "changeit"
-		alias := "keployCA"
-
-		logger.Debug("", zap.Any("java_home", javaHome), zap.Any("caCertsPath", cacertsPath), zap.Any("caPath", caPath))
-
-		if isJavaCAExist(ctx, alias, storePass, cacertsPath) {
-			logger.Debug("Java detected and CA already exists", zap.String("path", cacertsPath))
-			return nil
-		}
-
-		cmd := exec.CommandContext(ctx, "keytool", "-import", "-trustcacerts", "-keystore", cacertsPath, "-storepass", storePass, "-noprompt", "-alias", alias, "-file", caPath)
-		cmdOutput, err := cmd.CombinedOutput()
-
-		if err != nil {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-				utils.LogError(logger, err, "Java detected but failed to import CA", zap.String("output", string(cmdOutput)))
-				return err
-			}
-		}
-
-		logger.Debug("Java detected and successfully imported CA", zap.String("path", cacertsPath), zap.String("output", string(cmdOutput)))
-		logger.Debug("Successfully imported CA", zap.Any("", cmdOutput))
-	} else {
-		logger.Debug("Java is not installed on the system")
-	}
-	return nil
-}
-
 // TODO: This function should be used even before starting the proxy server. It should be called just after the keploy is started.
 // because the custom ca in case of NODE is set via env variable NODE_EXTRA_CA_CERTS and env variables can be set only on startup.
 // As in case of unit test integration, we are starting the proxy via api.
 
-// SetupCA setups custom certificate authority to handle TLS connections
-func SetupCA(ctx context.Context, logger *zap.Logger) error {
-	caPaths, err := getCaPaths()
+// SetupCA setups custom certificate authority to handle TLS connections. cfg
+// lets users point at their own CA cert/key (via --tls-ca-cert/--tls-ca-key);
+// when left empty, a per-installation CA under ~/.keploy/ca/ is used,
+// generating one on first run instead of sharing the embedded key across
+// every Keploy install.
+func SetupCA(ctx context.Context, logger *zap.Logger, cfg CAConfig) error {
+	setExpirySafetyMargin(cfg.ExpirySafetyMargin)
+	setGlobalLeafCacheSize(cfg.LeafCacheSize)
+
+	root, err := resolveRootCA(cfg)
 	if err != nil {
-		utils.LogError(logger, err, "Failed to find the CA store path")
+		utils.LogError(logger, err, "Failed to resolve the root CA cert/key")
 		return err
 	}
+	SetActiveCA(root)
 
-	for _, path := range caPaths {
-		caPath := filepath.Join(path, "ca.crt")
-
-		fs, err := os.Create(caPath)
-		if err != nil {
-			utils.LogError(logger, err, "Failed to create path for ca certificate", zap.Any("root store path", path))
-			return err
-		}
-
-		_, err = fs.Write(caCrt)
-		if err != nil {
-			utils.LogError(logger, err, "Failed to write custom ca certificate", zap.Any("root store path", path))
-			return err
-		}
-
-		// install CA in the java keystore if java is installed
-		err = installJavaCA(ctx, logger, caPath)
-		if err != nil {
-			utils.LogError(logger, err, "Failed to install CA in the java keystore")
-			return err
-		}
+	// Tell users about an expiring CA at startup rather than leaving them to
+	// discover it mid-replay via a cryptic handshake failure.
+	if err := checkCAExpiry(logger, root.Cert, getExpirySafetyMargin()); err != nil {
+		utils.LogError(logger, err, "Root CA is expiring soon; generate or supply a new one")
 	}
 
-	// Update the trusted CAs store
-	err = updateCaStore(ctx)
+	// The root CA's key is only ever used to mint a short-lived intermediate;
+	// leaf signing (CertForClient) never touches it again after this point.
+	intermediateCert, intermediateKey, err := newIntermediateCA(root.Cert, root.Key)
 	if err != nil {
-		utils.LogError(logger, err, "Failed to update the CA store")
+		utils.LogError(logger, err, "Failed to mint the initial intermediate CA")
 		return err
 	}
+	activeIntermediate.set(intermediateCert, intermediateKey)
 
-	tempCertPath, err := extractCertToTemp()
+	go rotateIntermediate(ctx, logger, root.Cert, root.Key, activeIntermediate)
+
+	tempCertPath, err := extractCertToTemp(root.CertPEM)
 	if err != nil {
 		utils.LogError(logger, err, "Failed to extract certificate to tmp folder")
 		return err
 	}
 
-	// for node
-	err = os.Setenv("NODE_EXTRA_CA_CERTS", tempCertPath)
-	if err != nil {
-		utils.LogError(logger, err, "Failed to set environment variable NODE_EXTRA_CA_CERTS")
+	// Trust the CA everywhere apps under test might look for one: the OS
+	// store, Java/other JDK keystores, NSS databases (Firefox/Chromium),
+	// and the env vars curl/Ruby/.NET/Deno/Go fall back to. The combined
+	// change-set is persisted so `keploy cert uninstall` can reverse it.
+	if _, err := trustinstaller.Install(ctx, logger, tempCertPath, trustinstaller.DefaultInstallers()); err != nil {
+		utils.LogError(logger, err, "Failed to install CA trust")
 		return err
 	}
 
-	// for python
-	err = os.Setenv("REQUESTS_CA_BUNDLE", tempCertPath)
+	return nil
+}
+
+// parseCAPair decodes a PEM-encoded CA certificate and private key pair and
+// returns them ready to use as a cfssl/x509 signer.
+func parseCAPair(certPEM, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("failed to decode CA certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
 	if err != nil {
-		utils.LogError(logger, err, "Failed to set environment variable REQUESTS_CA_BUNDLE")
-		return err
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
 	}
-	return nil
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("failed to decode CA private key PEM")
+	}
+
+	key, err := parsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("CA private key does not implement crypto.Signer")
+	}
+
+	return cert, signer, nil
+}
+
+// parsePrivateKey tries the DER encodings commonly produced for CA keys,
+// in the order they're most likely to occur.
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key format")
 }
 
 // SrcPortToDstURL map is used to store the mapping between source port and DstURL for the TLS connection
@@ -255,7 +173,7 @@ var SrcPortToDstURL = sync.Map{}
 
 var setLogLevelOnce sync.Once
 
-func CertForClient(logger *zap.Logger, clientHello *tls.ClientHelloInfo, caPrivKey any, caCertParsed *x509.Certificate, backdate time.Time) (*tls.Certificate, error) {
+func CertForClient(logger *zap.Logger, clientHello *tls.ClientHelloInfo, backdate time.Time) (*tls.Certificate, error) {
 
 	// Ensure log level is set only once
 
@@ -283,8 +201,19 @@ func CertForClient(logger *zap.Logger, clientHello *tls.ClientHelloInfo, caPrivK
 	remoteAddr := clientHello.Conn.RemoteAddr().(*net.TCPAddr)
 	sourcePort := remoteAddr.Port
 
+	// The port->host mapping must update on every connection, so it stays
+	// outside the leaf cache path below.
 	SrcPortToDstURL.Store(sourcePort, dstURL)
 
+	return getGlobalLeafCache().getOrSign(clientHello.ServerName, backdate, func() (*tls.Certificate, error) {
+		return signLeafCert(logger, clientHello, backdate)
+	})
+}
+
+// signLeafCert runs a full CFSSL sign for clientHello.ServerName. It's the
+// expensive path globalLeafCache exists to avoid repeating on every
+// handshake.
+func signLeafCert(logger *zap.Logger, clientHello *tls.ClientHelloInfo, backdate time.Time) (*tls.Certificate, error) {
 	serverReq := &csr.CertificateRequest{
 		//Make the name accordng to the ip of the request
 		CN: clientHello.ServerName,
@@ -298,11 +227,41 @@ func CertForClient(logger *zap.Logger, clientHello *tls.ClientHelloInfo, caPrivK
 	if err != nil {
 		return nil, fmt.Errorf("failed to create server CSR: %v", err)
 	}
-	cryptoSigner, ok := caPrivKey.(crypto.Signer)
-	if !ok {
-		return nil, fmt.Errorf("failed to typecast the caPrivKey")
+
+	root := getActiveCA()
+
+	// Refuse hosts the root CA isn't permitted to certify for (name
+	// constraints only apply to auto-generated per-installation CAs; the
+	// embedded CA is left unconstrained, see checkNameConstraints).
+	if root != nil {
+		if err := checkNameConstraints(root.Cert, clientHello.ServerName); err != nil {
+			return nil, err
+		}
 	}
-	signerd, err := local.NewSigner(cryptoSigner, caCertParsed, signer.DefaultSigAlgo(cryptoSigner), nil)
+
+	// Leaves are signed by the short-lived intermediate, never the root. If
+	// SetupCA hasn't run yet (e.g. direct callers/tests), fall back to
+	// minting one on the fly from the active root CA, which tests can set
+	// themselves via SetActiveCA instead of relying on package globals.
+	intermediateCert, intermediateKey := activeIntermediate.get()
+	if intermediateCert == nil || intermediateKey == nil {
+		if root == nil {
+			return nil, fmt.Errorf("no root CA available: call SetupCA or SetActiveCA first")
+		}
+		intermediateCert, intermediateKey, err = newIntermediateCA(root.Cert, root.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mint intermediate CA: %w", err)
+		}
+		activeIntermediate.set(intermediateCert, intermediateKey)
+	}
+
+	// Refuse to sign with a CA that's about to expire rather than handing
+	// out a leaf that browsers/crypto/tls will reject mid-handshake.
+	if err := checkCAExpiry(logger, intermediateCert, getExpirySafetyMargin()); err != nil {
+		return nil, err
+	}
+
+	signerd, err := local.NewSigner(intermediateKey, intermediateCert, signer.DefaultSigAlgo(intermediateKey), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create signer: %v", err)
 	}
@@ -325,7 +284,9 @@ func CertForClient(logger *zap.Logger, clientHello *tls.ClientHelloInfo, caPrivK
 		Request:   string(serverCsr),
 		Profile:   "web",
 		NotBefore: backdate.AddDate(-1, 0, 0),
-		NotAfter:  time.Now().AddDate(1, 0, 0),
+		// Clamped to the intermediate's own expiry so we never mint a leaf
+		// that outlives its issuer.
+		NotAfter: clampLeafNotAfter(intermediateCert.NotAfter),
 	}
 
 	serverCert, err := signerd.Sign(signReq)
@@ -341,5 +302,15 @@ func CertForClient(logger *zap.Logger, clientHello *tls.ClientHelloInfo, caPrivK
 		return nil, fmt.Errorf("failed to load server certificate and key: %v", err)
 	}
 
+	// Ship the intermediate alongside the leaf so clients can build the
+	// chain up to the root they already trust.
+	serverTLSCert.Certificate = append(serverTLSCert.Certificate, intermediateCert.Raw)
+
 	return &serverTLSCert, nil
 }
+
+// UninstallCA reverses every trust-store change SetupCA made, as recorded in
+// ~/.keploy/ca/installed.json. It backs the `keploy cert uninstall` command.
+func UninstallCA(ctx context.Context, logger *zap.Logger) error {
+	return trustinstaller.Uninstall(ctx, logger, trustinstaller.DefaultInstallers())
+}
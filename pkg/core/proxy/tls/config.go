@@ -0,0 +1,312 @@
+//go:build linux
+
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/youmark/pkcs8"
+)
+
+// rootValidity is how long a per-installation root CA, generated when the
+// user hasn't supplied their own, is valid for.
+const rootValidity = 10 * 365 * 24 * time.Hour
+
+// CAConfig controls where SetupCA sources its root certificate authority
+// from. It's the equivalent of the schema-registry-statistics `--tls-ca-cert`
+// flag and the etcd transport's `tlsCACertFile` loader, adapted to also cover
+// the matching private key.
+type CAConfig struct {
+	// CertPath and KeyPath point at a PEM-encoded CA certificate and
+	// (optionally passphrase-encrypted PKCS#8) private key on disk. When
+	// both are empty, SetupCA falls back to a per-installation CA kept
+	// under ~/.keploy/ca/, generating one on first run.
+	CertPath      string
+	KeyPath       string
+	KeyPassphrase string
+
+	// ExpirySafetyMargin is how close to a CA's NotAfter CertForClient is
+	// still allowed to sign new leaves with it. Defaults to
+	// defaultExpirySafetyMargin (24h) when zero.
+	ExpirySafetyMargin time.Duration
+
+	// PermittedDomains restricts an auto-generated per-installation root CA
+	// to these hosts/CIDRs/IPs (plus localhost/127.0.0.0/8/::1/128, which
+	// are always included), via --tls-ca-permitted-domains or
+	// CollectPermittedDomains. It has no effect on the embedded CA, which
+	// stays unconstrained for backward compatibility, or on a user-supplied
+	// CertPath/KeyPath, whose constraints are already baked in.
+	PermittedDomains []string
+
+	// LeafCacheSize bounds how many per-SNI signed leaf certificates
+	// CertForClient keeps cached at once. Defaults to defaultLeafCacheSize
+	// (1024) when zero.
+	LeafCacheSize int
+}
+
+// CAMaterial bundles a CA certificate with its signer so the pair can be
+// passed, stored, and swapped as a single unit. Keeping it as a plain struct
+// rather than package globals lets tests build their own root CA and drive
+// SetupCA/CertForClient against it directly.
+type CAMaterial struct {
+	Cert    *x509.Certificate
+	Key     crypto.Signer
+	CertPEM []byte
+}
+
+var (
+	activeRootMu sync.RWMutex
+	activeRoot   *CAMaterial
+)
+
+// SetActiveCA overrides the root CA material SetupCA/CertForClient operate
+// on. It exists so tests can inject their own CA instead of relying on the
+// embedded asset or touching the filesystem.
+func SetActiveCA(ca *CAMaterial) {
+	activeRootMu.Lock()
+	defer activeRootMu.Unlock()
+	activeRoot = ca
+}
+
+func getActiveCA() *CAMaterial {
+	activeRootMu.RLock()
+	defer activeRootMu.RUnlock()
+	return activeRoot
+}
+
+// keployCADir returns ~/.keploy/ca/, creating it if necessary.
+func keployCADir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve home directory: %w", err)
+	}
+	dir := filepath.Join(home, ".keploy", "ca")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// resolveRootCA loads the root CA to use, in priority order: an explicit
+// user-supplied cert/key (cfg), a previously generated per-installation CA
+// under ~/.keploy/ca/, a freshly generated per-installation CA, and only as
+// a last resort the embedded shared CA.
+func resolveRootCA(cfg CAConfig) (*CAMaterial, error) {
+	if cfg.CertPath != "" || cfg.KeyPath != "" {
+		return loadRootCAFromDisk(cfg.CertPath, cfg.KeyPath, cfg.KeyPassphrase)
+	}
+
+	dir, err := keployCADir()
+	if err != nil {
+		// No writable home directory: fall back to the embedded CA rather
+		// than failing startup outright.
+		cert, key, err := parseCAPair(caCrt, caPKey)
+		if err != nil {
+			return nil, err
+		}
+		return &CAMaterial{Cert: cert, Key: key, CertPEM: caCrt}, nil
+	}
+
+	certPath := filepath.Join(dir, "ca.crt")
+	keyPath := filepath.Join(dir, "ca.key")
+
+	if fileExists(certPath) && fileExists(keyPath) {
+		existing, err := loadRootCAFromDisk(certPath, keyPath, "")
+		if err != nil {
+			return nil, err
+		}
+
+		// Re-sign rather than silently staying constrained to whatever was
+		// true the first time this CA was generated: a host observed later
+		// (or passed in newly via --tls-ca-permitted-domains) must still be
+		// interceptable.
+		if widened, regen := widenPermittedDomains(existing.Cert, cfg.PermittedDomains); regen {
+			return reSignPerInstallationRootCA(certPath, existing, widened)
+		}
+		return existing, nil
+	}
+
+	return generatePerInstallationRootCA(certPath, keyPath, cfg.PermittedDomains)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// loadRootCAFromDisk reads a PEM-encoded CA certificate and private key
+// from disk, decrypting the key first if a passphrase is supplied.
+func loadRootCAFromDisk(certPath, keyPath, passphrase string) (*CAMaterial, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate %s: %w", certPath, err)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA private key %s: %w", keyPath, err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("failed to decode CA certificate PEM at %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate %s: %w", certPath, err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("failed to decode CA private key PEM at %s", keyPath)
+	}
+
+	var rawKey crypto.PrivateKey
+	if passphrase != "" {
+		rawKey, err = pkcs8.ParsePKCS8PrivateKey(keyBlock.Bytes, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt CA private key %s: %w", keyPath, err)
+		}
+	} else {
+		rawKey, err = parsePrivateKey(keyBlock.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CA private key %s: %w", keyPath, err)
+		}
+	}
+
+	signer, ok := rawKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CA private key %s does not implement crypto.Signer", keyPath)
+	}
+
+	return &CAMaterial{Cert: cert, Key: signer, CertPEM: certPEM}, nil
+}
+
+// generatePerInstallationRootCA mints a fresh root CA and persists it to
+// certPath/keyPath so subsequent runs reuse it instead of sharing the
+// embedded key across every Keploy install. When permittedDomains is
+// non-empty the CA is constrained (per RFC 5280 §4.2.1.10) to those hosts
+// plus localhost/127.0.0.0/8/::1/128: once trusted, an unconstrained CA
+// could forge certificates for any domain, so constraining it to the hosts
+// actually under test limits the blast radius. With no permittedDomains
+// (the default until a caller supplies or derives one) the CA is left
+// unconstrained, since a CA permanently locked to only the defaults could
+// never be used to intercept real traffic.
+func generatePerInstallationRootCA(certPath, keyPath string, permittedDomains []string) (*CAMaterial, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate root CA key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate root CA serial: %w", err)
+	}
+
+	dnsDomains, ipRanges := buildNameConstraints(permittedDomains)
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "Keploy Root CA",
+			Organization: []string{"Keploy"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(rootValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+		PermittedDNSDomains:   dnsDomains,
+		PermittedIPRanges:     ipRanges,
+	}
+	if len(dnsDomains) > 0 || len(ipRanges) > 0 {
+		template.PermittedDNSDomainsCritical = true
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to self-sign root CA: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse freshly generated root CA: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal root CA key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to persist root CA certificate: %w", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to persist root CA key: %w", err)
+	}
+
+	return &CAMaterial{Cert: cert, Key: key, CertPEM: certPEM}, nil
+}
+
+// reSignPerInstallationRootCA re-signs existing's certificate with a widened
+// permittedDomains set, reusing its existing key rather than generating a
+// new one. Rotating the key here as well would orphan every trust-store
+// copy already installed from it (javakeystore, NSSDB, the system store),
+// none of which get re-synced just because the CA widened its constraints.
+func reSignPerInstallationRootCA(certPath string, existing *CAMaterial, permittedDomains []string) (*CAMaterial, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate root CA serial: %w", err)
+	}
+
+	dnsDomains, ipRanges := buildNameConstraints(permittedDomains)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               existing.Cert.Subject,
+		NotBefore:             existing.Cert.NotBefore,
+		NotAfter:              existing.Cert.NotAfter,
+		KeyUsage:              existing.Cert.KeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            existing.Cert.MaxPathLen,
+		PermittedDNSDomains:   dnsDomains,
+		PermittedIPRanges:     ipRanges,
+	}
+	if len(dnsDomains) > 0 || len(ipRanges) > 0 {
+		template.PermittedDNSDomainsCritical = true
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, existing.Key.Public(), existing.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-sign root CA with widened constraints: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse re-signed root CA: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to persist re-signed root CA certificate: %w", err)
+	}
+
+	return &CAMaterial{Cert: cert, Key: existing.Key, CertPEM: certPEM}, nil
+}
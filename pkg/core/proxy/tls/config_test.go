@@ -0,0 +1,199 @@
+//go:build linux
+
+package tls
+
+import (
+	"crypto/ecdsa"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/youmark/pkcs8"
+)
+
+// writeEncryptedTestCA mints a throwaway CA and persists it with its private
+// key PKCS#8-encrypted under passphrase, mirroring what a user-supplied
+// --tls-ca-key might look like.
+func writeEncryptedTestCA(t *testing.T, dir, passphrase string) (certPath, keyPath string) {
+	t.Helper()
+
+	certPath = filepath.Join(dir, "encrypted.crt")
+	keyPath = filepath.Join(dir, "encrypted.key")
+	material, err := generatePerInstallationRootCA(certPath, keyPath, nil)
+	if err != nil {
+		t.Fatalf("failed to set up test CA: %v", err)
+	}
+
+	keyDER, err := pkcs8.MarshalPrivateKey(material.Key, []byte(passphrase), nil)
+	if err != nil {
+		t.Fatalf("failed to marshal encrypted test key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatalf("failed to write encrypted test key: %v", err)
+	}
+
+	return certPath, keyPath
+}
+
+func TestLoadRootCAFromDisk(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("valid unencrypted cert and key", func(t *testing.T) {
+		certPath := filepath.Join(dir, "plain.crt")
+		keyPath := filepath.Join(dir, "plain.key")
+		if _, err := generatePerInstallationRootCA(certPath, keyPath, nil); err != nil {
+			t.Fatalf("failed to set up test CA: %v", err)
+		}
+
+		material, err := loadRootCAFromDisk(certPath, keyPath, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if material.Cert.Subject.CommonName != "Keploy Root CA" {
+			t.Fatalf("unexpected CA loaded: %q", material.Cert.Subject.CommonName)
+		}
+	})
+
+	t.Run("passphrase-encrypted key with the correct passphrase", func(t *testing.T) {
+		certPath, keyPath := writeEncryptedTestCA(t, dir, "correct-horse-battery-staple")
+		if _, err := loadRootCAFromDisk(certPath, keyPath, "correct-horse-battery-staple"); err != nil {
+			t.Fatalf("unexpected error decrypting with the right passphrase: %v", err)
+		}
+	})
+
+	t.Run("passphrase-encrypted key with the wrong passphrase", func(t *testing.T) {
+		certPath, keyPath := writeEncryptedTestCA(t, dir, "correct-horse-battery-staple")
+		if _, err := loadRootCAFromDisk(certPath, keyPath, "wrong-password"); err == nil {
+			t.Fatalf("expected an error for a wrong passphrase")
+		}
+	})
+
+	t.Run("malformed certificate PEM", func(t *testing.T) {
+		_, validKeyPath := writeEncryptedTestCA(t, dir, "unused")
+		certPath := filepath.Join(dir, "malformed.crt")
+		if err := os.WriteFile(certPath, []byte("not a pem file"), 0644); err != nil {
+			t.Fatalf("failed to write malformed cert: %v", err)
+		}
+		if _, err := loadRootCAFromDisk(certPath, validKeyPath, "unused"); err == nil {
+			t.Fatalf("expected an error for a malformed certificate PEM")
+		}
+	})
+
+	t.Run("malformed private key PEM", func(t *testing.T) {
+		validCertPath := filepath.Join(dir, "valid-for-bad-key.crt")
+		keyPath := filepath.Join(dir, "valid-for-bad-key.key")
+		if _, err := generatePerInstallationRootCA(validCertPath, keyPath, nil); err != nil {
+			t.Fatalf("failed to set up test CA: %v", err)
+		}
+		if err := os.WriteFile(keyPath, []byte("not a pem file"), 0600); err != nil {
+			t.Fatalf("failed to overwrite key with garbage: %v", err)
+		}
+		if _, err := loadRootCAFromDisk(validCertPath, keyPath, ""); err == nil {
+			t.Fatalf("expected an error for a malformed private key PEM")
+		}
+	})
+
+	t.Run("unsupported private key format", func(t *testing.T) {
+		validCertPath := filepath.Join(dir, "valid-for-unsupported-key.crt")
+		keyPath := filepath.Join(dir, "valid-for-unsupported-key.key")
+		if _, err := generatePerInstallationRootCA(validCertPath, keyPath, nil); err != nil {
+			t.Fatalf("failed to set up test CA: %v", err)
+		}
+		garbage := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("this is not a valid DER-encoded key")})
+		if err := os.WriteFile(keyPath, garbage, 0600); err != nil {
+			t.Fatalf("failed to overwrite key with an unsupported format: %v", err)
+		}
+		if _, err := loadRootCAFromDisk(validCertPath, keyPath, ""); err == nil {
+			t.Fatalf("expected an error for an unsupported private key format")
+		}
+	})
+}
+
+func TestResolveRootCAExplicitOverride(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "custom.crt")
+	keyPath := filepath.Join(dir, "custom.key")
+	if _, err := generatePerInstallationRootCA(certPath, keyPath, nil); err != nil {
+		t.Fatalf("failed to set up test CA: %v", err)
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	material, err := resolveRootCA(CAConfig{CertPath: certPath, KeyPath: keyPath})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if material.Cert.Subject.CommonName != "Keploy Root CA" {
+		t.Fatalf("expected the explicit cert/key to be loaded")
+	}
+	if fileExists(filepath.Join(home, ".keploy", "ca", "ca.crt")) {
+		t.Fatalf("expected no per-installation CA when an explicit cert/key is supplied")
+	}
+}
+
+func TestResolveRootCAGeneratesPerInstallationCA(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if _, err := resolveRootCA(CAConfig{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	certPath := filepath.Join(home, ".keploy", "ca", "ca.crt")
+	keyPath := filepath.Join(home, ".keploy", "ca", "ca.key")
+	if !fileExists(certPath) || !fileExists(keyPath) {
+		t.Fatalf("expected resolveRootCA to persist a per-installation CA under ~/.keploy/ca/")
+	}
+}
+
+func TestResolveRootCAReusesPersistedCAWithoutNewDomains(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	first, err := resolveRootCA(CAConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error on first resolve: %v", err)
+	}
+	second, err := resolveRootCA(CAConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error on second resolve: %v", err)
+	}
+
+	if string(first.CertPEM) != string(second.CertPEM) {
+		t.Fatalf("expected the persisted CA to be reused unchanged across calls")
+	}
+}
+
+func TestResolveRootCAWidensWithoutRotatingKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	first, err := resolveRootCA(CAConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error on first resolve: %v", err)
+	}
+
+	widened, err := resolveRootCA(CAConfig{PermittedDomains: []string{"stripe.com"}})
+	if err != nil {
+		t.Fatalf("unexpected error widening permitted domains: %v", err)
+	}
+
+	if string(first.CertPEM) == string(widened.CertPEM) {
+		t.Fatalf("expected the certificate to change once constraints widen")
+	}
+
+	firstPub, ok := first.Key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		t.Fatalf("expected the original CA key to be an ECDSA key")
+	}
+	widenedPub, ok := widened.Key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		t.Fatalf("expected the widened CA key to be an ECDSA key")
+	}
+	if !firstPub.Equal(widenedPub) {
+		t.Fatalf("expected widening the permitted domains to keep the existing CA key, got a new one")
+	}
+}
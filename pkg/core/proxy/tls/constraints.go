@@ -0,0 +1,179 @@
+//go:build linux
+
+package tls
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const localhostDomain = "localhost"
+
+// defaultPermittedIPRanges are always trusted for auto-generated
+// per-installation CAs, since the proxy itself talks to apps over loopback.
+var defaultPermittedIPRanges = []*net.IPNet{
+	{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+}
+
+// buildNameConstraints turns a permitted-domain list (hostnames, CIDRs, or
+// bare IPs, e.g. from --tls-ca-permitted-domains) into the DNS/IP range
+// sets x509.Certificate expects, always including localhost/127.0.0.0/8/::1/128
+// so the proxy keeps working against the app under test. An empty list
+// returns no constraints at all rather than baking in just the defaults:
+// until we actually know which hosts are under test, constraining the CA
+// would permanently lock it out of intercepting anything else.
+func buildNameConstraints(extra []string) (dnsDomains []string, ipRanges []*net.IPNet) {
+	if len(extra) == 0 {
+		return nil, nil
+	}
+
+	dnsDomains = append(dnsDomains, localhostDomain)
+	ipRanges = append(ipRanges, defaultPermittedIPRanges...)
+
+	for _, e := range extra {
+		if _, network, err := net.ParseCIDR(e); err == nil {
+			ipRanges = append(ipRanges, network)
+			continue
+		}
+		if ip := net.ParseIP(e); ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			ipRanges = append(ipRanges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		dnsDomains = append(dnsDomains, e)
+	}
+
+	return dnsDomains, ipRanges
+}
+
+// widenPermittedDomains compares requested against the domains/ranges
+// already baked into cert and reports whether regenerating the CA is
+// needed to cover all of them. An already-unconstrained cert is left alone
+// unless requested is non-empty, in which case the CA is now opting into
+// constraints for the first time. The returned slice, when regen is true,
+// is ready to pass back into buildNameConstraints.
+func widenPermittedDomains(cert *x509.Certificate, requested []string) (widened []string, regen bool) {
+	if len(requested) == 0 {
+		return nil, false
+	}
+
+	if len(cert.PermittedDNSDomains) == 0 && len(cert.PermittedIPRanges) == 0 {
+		return requested, true
+	}
+
+	seen := make(map[string]struct{}, len(cert.PermittedDNSDomains)+len(cert.PermittedIPRanges))
+	for _, d := range cert.PermittedDNSDomains {
+		seen[d] = struct{}{}
+		widened = append(widened, d)
+	}
+	for _, r := range cert.PermittedIPRanges {
+		seen[r.String()] = struct{}{}
+		widened = append(widened, r.String())
+	}
+
+	for _, d := range requested {
+		// Compare IPs/CIDRs in the same canonical form the cert's ranges
+		// stringify to, so a bare "10.0.0.5" matches an existing 10.0.0.5/32.
+		key := d
+		if _, network, err := net.ParseCIDR(d); err == nil {
+			key = network.String()
+		} else if ip := net.ParseIP(d); ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			key = (&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}).String()
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		widened = append(widened, d)
+		regen = true
+	}
+
+	if !regen {
+		return nil, false
+	}
+	return widened, true
+}
+
+// checkNameConstraints refuses an SNI the root CA isn't permitted to
+// certify for (per its RFC 5280 §4.2.1.10 NameConstraints), so we fail fast
+// instead of producing a leaf that clients will reject during chain
+// validation anyway. A root with no constraints (e.g. the embedded,
+// backward-compatible CA) is treated as unconstrained.
+func checkNameConstraints(root *x509.Certificate, sni string) error {
+	if root == nil || (len(root.PermittedDNSDomains) == 0 && len(root.PermittedIPRanges) == 0) {
+		return nil
+	}
+
+	if ip := net.ParseIP(sni); ip != nil {
+		for _, r := range root.PermittedIPRanges {
+			if r.Contains(ip) {
+				return nil
+			}
+		}
+		return fmt.Errorf("CA is not permitted to sign for IP %q: outside its permitted IP ranges", sni)
+	}
+
+	for _, domain := range root.PermittedDNSDomains {
+		if sni == domain || strings.HasSuffix(sni, "."+domain) {
+			return nil
+		}
+	}
+	return fmt.Errorf("CA is not permitted to sign for host %q: outside its permitted DNS domains", sni)
+}
+
+// CollectPermittedDomains extracts de-duplicated hostnames from a set of
+// recorded DstURLs, for auto-deriving the permitted domain set when the
+// user doesn't pass --tls-ca-permitted-domains explicitly.
+func CollectPermittedDomains(dstURLs []string) []string {
+	seen := make(map[string]struct{})
+	var domains []string
+	for _, raw := range dstURLs {
+		host := hostFromDstURL(raw)
+		if host == "" {
+			continue
+		}
+		if _, ok := seen[host]; ok {
+			continue
+		}
+		seen[host] = struct{}{}
+		domains = append(domains, host)
+	}
+	return domains
+}
+
+// hostFromDstURL pulls the bare hostname out of a DstURL, which may or may
+// not carry a scheme (e.g. "https://api.stripe.com:443/v1" vs.
+// "api.stripe.com:443"). url.Parse alone isn't enough: Go's URL grammar
+// accepts dots in a scheme, so a scheme-less "host:port" string like
+// "api.stripe.com:443" parses with "api.stripe.com" as the scheme and an
+// empty Hostname(), silently producing the wrong value. We only trust
+// url.Parse when a "://" is actually present, and otherwise split host:port
+// ourselves.
+func hostFromDstURL(raw string) string {
+	if strings.Contains(raw, "://") {
+		if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+		return ""
+	}
+
+	candidate := raw
+	if idx := strings.IndexByte(candidate, '/'); idx != -1 {
+		candidate = candidate[:idx]
+	}
+	if host, _, err := net.SplitHostPort(candidate); err == nil {
+		return host
+	}
+	return candidate
+}
@@ -0,0 +1,117 @@
+//go:build linux
+
+package tls
+
+import (
+	"crypto/x509"
+	"net"
+	"testing"
+)
+
+func constrainedCert(dnsDomains []string, ipRanges []*net.IPNet) *x509.Certificate {
+	return &x509.Certificate{
+		PermittedDNSDomains: dnsDomains,
+		PermittedIPRanges:   ipRanges,
+	}
+}
+
+func TestCheckNameConstraints(t *testing.T) {
+	_, loopbackRange, err := net.ParseCIDR("127.0.0.0/8")
+	if err != nil {
+		t.Fatalf("failed to parse test CIDR: %v", err)
+	}
+	constrained := constrainedCert([]string{"stripe.com", "localhost"}, []*net.IPNet{loopbackRange})
+	unconstrained := &x509.Certificate{}
+
+	tests := []struct {
+		name    string
+		root    *x509.Certificate
+		sni     string
+		wantErr bool
+	}{
+		{name: "nil root is treated as unconstrained", root: nil, sni: "evil.example.com", wantErr: false},
+		{name: "unconstrained CA allows any host", root: unconstrained, sni: "evil.example.com", wantErr: false},
+		{name: "exact domain match is permitted", root: constrained, sni: "stripe.com", wantErr: false},
+		{name: "subdomain of a permitted domain is permitted", root: constrained, sni: "api.stripe.com", wantErr: false},
+		{name: "unrelated host is refused", root: constrained, sni: "evil.example.com", wantErr: true},
+		{name: "lookalike suffix without a dot is refused", root: constrained, sni: "notstripe.com", wantErr: true},
+		{name: "IP within a permitted range is permitted", root: constrained, sni: "127.0.0.1", wantErr: false},
+		{name: "IP outside any permitted range is refused", root: constrained, sni: "8.8.8.8", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNameConstraints(tt.root, tt.sni)
+			if tt.wantErr && err == nil {
+				t.Fatalf("expected an error for SNI %q, got nil", tt.sni)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected no error for SNI %q, got %v", tt.sni, err)
+			}
+		})
+	}
+}
+
+func TestWidenPermittedDomains(t *testing.T) {
+	t.Run("no requested domains never triggers regen", func(t *testing.T) {
+		cert := constrainedCert([]string{"stripe.com"}, nil)
+		_, regen := widenPermittedDomains(cert, nil)
+		if regen {
+			t.Fatalf("expected no regen when nothing is requested")
+		}
+	})
+
+	t.Run("unconstrained cert opts in on first request", func(t *testing.T) {
+		cert := &x509.Certificate{}
+		widened, regen := widenPermittedDomains(cert, []string{"stripe.com"})
+		if !regen {
+			t.Fatalf("expected regen when an unconstrained CA is given a requested domain list")
+		}
+		if len(widened) != 1 || widened[0] != "stripe.com" {
+			t.Fatalf("expected widened = [stripe.com], got %v", widened)
+		}
+	})
+
+	t.Run("already-covered domain does not trigger regen", func(t *testing.T) {
+		cert := constrainedCert([]string{"stripe.com"}, nil)
+		_, regen := widenPermittedDomains(cert, []string{"stripe.com"})
+		if regen {
+			t.Fatalf("expected no regen when the requested domain is already permitted")
+		}
+	})
+
+	t.Run("new domain triggers regen and keeps the existing ones", func(t *testing.T) {
+		cert := constrainedCert([]string{"stripe.com"}, nil)
+		widened, regen := widenPermittedDomains(cert, []string{"stripe.com", "github.com"})
+		if !regen {
+			t.Fatalf("expected regen when a new domain is requested")
+		}
+		got := make(map[string]bool)
+		for _, d := range widened {
+			got[d] = true
+		}
+		if !got["stripe.com"] || !got["github.com"] {
+			t.Fatalf("expected widened to contain both domains, got %v", widened)
+		}
+	})
+}
+
+func TestHostFromDstURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: "https://api.stripe.com:443/v1/charges", want: "api.stripe.com"},
+		{raw: "api.stripe.com:443", want: "api.stripe.com"},
+		{raw: "api.stripe.com", want: "api.stripe.com"},
+		{raw: "127.0.0.1:8080", want: "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if got := hostFromDstURL(tt.raw); got != tt.want {
+				t.Fatalf("hostFromDstURL(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
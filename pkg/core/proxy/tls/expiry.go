@@ -0,0 +1,86 @@
+//go:build linux
+
+package tls
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultExpirySafetyMargin is how close to a CA's NotAfter we still allow
+// it to sign new leaves. Analogous to the root/intermediate expiry gate in
+// Consul's CA manager.
+const defaultExpirySafetyMargin = 24 * time.Hour
+
+// expiryWarnWindow is how far out we start logging a warning about an
+// approaching CA expiry, well before the hard safety margin kicks in.
+const expiryWarnWindow = 30 * 24 * time.Hour
+
+// expirySafetyMargin is configurable via CAConfig.ExpirySafetyMargin; it's
+// stored as an atomic so CertForClient can read it without its own lock.
+var expirySafetyMargin atomic.Int64
+
+func init() {
+	expirySafetyMargin.Store(int64(defaultExpirySafetyMargin))
+}
+
+func setExpirySafetyMargin(margin time.Duration) {
+	if margin <= 0 {
+		margin = defaultExpirySafetyMargin
+	}
+	expirySafetyMargin.Store(int64(margin))
+}
+
+func getExpirySafetyMargin() time.Duration {
+	return time.Duration(expirySafetyMargin.Load())
+}
+
+// ErrCAExpiringSoon is returned when a CA's remaining validity has dropped
+// inside the configured safety margin, so it can no longer safely sign new
+// leaves.
+type ErrCAExpiringSoon struct {
+	CommonName string
+	NotAfter   time.Time
+	Margin     time.Duration
+}
+
+func (e *ErrCAExpiringSoon) Error() string {
+	return fmt.Sprintf("CA %q expires at %s, which is within the %s safety margin", e.CommonName, e.NotAfter.Format(time.RFC3339), e.Margin)
+}
+
+// checkCAExpiry refuses to sign further leaves once cert's remaining
+// validity drops inside margin, and otherwise logs the remaining lifetime
+// at warn level once it's within expiryWarnWindow, so operators notice an
+// expiring CA before replay fails with a cryptic handshake error.
+func checkCAExpiry(logger *zap.Logger, cert *x509.Certificate, margin time.Duration) error {
+	remaining := time.Until(cert.NotAfter)
+	if remaining <= margin {
+		return &ErrCAExpiringSoon{CommonName: cert.Subject.CommonName, NotAfter: cert.NotAfter, Margin: margin}
+	}
+
+	if remaining <= expiryWarnWindow {
+		logger.Warn("CA is approaching expiry",
+			zap.String("commonName", cert.Subject.CommonName),
+			zap.Time("notAfter", cert.NotAfter),
+			zap.Duration("remaining", remaining),
+		)
+	}
+
+	return nil
+}
+
+// clampLeafNotAfter ensures a leaf's validity window never outlives the CA
+// signing it, since browsers and Go's own crypto/tls verifier reject a leaf
+// whose NotAfter is past its issuer's.
+func clampLeafNotAfter(caNotAfter time.Time) time.Time {
+	latest := caNotAfter.Add(-time.Hour)
+	oneYearOut := time.Now().AddDate(1, 0, 0)
+	if oneYearOut.Before(latest) {
+		return oneYearOut
+	}
+	return latest
+}
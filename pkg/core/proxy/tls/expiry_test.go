@@ -0,0 +1,47 @@
+//go:build linux
+
+package tls
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClampLeafNotAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		caNotAfter time.Time
+		want       func(t *testing.T, got time.Time)
+	}{
+		{
+			name:       "CA outlives the default one-year leaf window",
+			caNotAfter: time.Now().AddDate(5, 0, 0),
+			want: func(t *testing.T, got time.Time) {
+				wantApprox := time.Now().AddDate(1, 0, 0)
+				if got.Sub(wantApprox).Abs() > time.Minute {
+					t.Fatalf("expected ~one year out, got %s (want ~%s)", got, wantApprox)
+				}
+			},
+		},
+		{
+			name:       "CA expires before the leaf would otherwise outlive it",
+			caNotAfter: time.Now().Add(48 * time.Hour),
+			want: func(t *testing.T, got time.Time) {
+				wantExact := time.Now().Add(48*time.Hour - time.Hour)
+				if got.Sub(wantExact).Abs() > time.Minute {
+					t.Fatalf("expected CA expiry minus one hour, got %s (want ~%s)", got, wantExact)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clampLeafNotAfter(tt.caNotAfter)
+			if !got.Before(tt.caNotAfter) {
+				t.Fatalf("clamped NotAfter %s must be strictly before CA NotAfter %s", got, tt.caNotAfter)
+			}
+			tt.want(t, got)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+//go:build linux
+
+package tls
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"go.keploy.io/server/v2/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// intermediateValidity is how long a freshly minted intermediate CA is
+	// valid for. Keeping it short-lived means the signing key the proxy
+	// process holds in memory is disposable, unlike the offline root key.
+	intermediateValidity = 90 * 24 * time.Hour
+
+	// intermediateRenewBefore is how far ahead of expiry rotateIntermediate
+	// mints and swaps in a replacement intermediate.
+	intermediateRenewBefore = 15 * 24 * time.Hour
+)
+
+// intermediateCA holds the currently active intermediate signing certificate
+// and key. CertForClient reads from it to sign leaf certificates, while
+// rotateIntermediate swaps in a freshly minted pair before the current one
+// expires. All access goes through the RWMutex so an in-flight handshake
+// never observes a half-updated pair.
+type intermediateCA struct {
+	mu   sync.RWMutex
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func (i *intermediateCA) get() (*x509.Certificate, crypto.Signer) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.cert, i.key
+}
+
+func (i *intermediateCA) set(cert *x509.Certificate, key crypto.Signer) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cert = cert
+	i.key = key
+}
+
+// activeIntermediate is the process-wide intermediate signer used by
+// CertForClient. SetupCA populates it at startup and rotateIntermediate
+// keeps it fresh for the lifetime of the process.
+var activeIntermediate = &intermediateCA{}
+
+// newIntermediateCA mints a fresh intermediate CA signed by the given root.
+// This mirrors the root/intermediate split caddypki uses: the root stays
+// the long-lived trust anchor, while this short-lived pair is the only
+// signing key the proxy process ever holds.
+func newIntermediateCA(rootCert *x509.Certificate, rootKey crypto.Signer) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate intermediate CA key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate intermediate CA serial: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "Keploy Intermediate CA",
+			Organization: rootCert.Subject.Organization,
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(intermediateValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, rootCert, key.Public(), rootKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign intermediate CA with root: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse freshly signed intermediate CA: %w", err)
+	}
+
+	return cert, key, nil
+}
+
+// rotateIntermediate keeps signer populated with a non-expired intermediate
+// CA for as long as ctx is alive, minting a replacement intermediateRenewBefore
+// ahead of expiry so in-flight handshakes are never interrupted by a
+// mid-rotation gap.
+func rotateIntermediate(ctx context.Context, logger *zap.Logger, rootCert *x509.Certificate, rootKey crypto.Signer, signer *intermediateCA) {
+	for {
+		cert, key, err := newIntermediateCA(rootCert, rootKey)
+		if err != nil {
+			utils.LogError(logger, err, "failed to mint intermediate CA, keeping the previous one in use")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Minute):
+				continue
+			}
+		}
+
+		signer.set(cert, key)
+		logger.Debug("rotated intermediate CA", zap.Time("notBefore", cert.NotBefore), zap.Time("notAfter", cert.NotAfter))
+
+		wait := time.Until(cert.NotAfter.Add(-intermediateRenewBefore))
+		if wait < 0 {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
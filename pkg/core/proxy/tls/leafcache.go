@@ -0,0 +1,119 @@
+//go:build linux
+
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultLeafCacheSize bounds how many per-SNI leaf certificates are kept
+// around at once.
+const defaultLeafCacheSize = 1024
+
+// leafCacheKey identifies a cached leaf. backdateDay buckets the backdate
+// used for time-frozen replay by day, since NotBefore depends on it, while
+// still letting identical requests within the same day share a cert.
+type leafCacheKey struct {
+	sni         string
+	backdateDay int64
+}
+
+type leafCacheEntry struct {
+	cert     *tls.Certificate
+	notAfter time.Time
+}
+
+// leafCache avoids paying a full CFSSL sign (fresh ECDSA key + CSR + sign)
+// on every TLS ClientHello, which otherwise dominates proxy latency under
+// load. Entries are treated as stale and re-signed an hour before their
+// leaf's own NotAfter, and
+// singleflight collapses concurrent handshakes for the same SNI into one
+// sign.
+type leafCache struct {
+	cache *lru.Cache[leafCacheKey, leafCacheEntry]
+	group singleflight.Group
+}
+
+func newLeafCache(size int) *leafCache {
+	if size <= 0 {
+		size = defaultLeafCacheSize
+	}
+	c, err := lru.New[leafCacheKey, leafCacheEntry](size)
+	if err != nil {
+		// Only returns an error for a non-positive size, which we've just
+		// guarded against above.
+		panic(fmt.Sprintf("tls: failed to create leaf cache: %v", err))
+	}
+	return &leafCache{cache: c}
+}
+
+var (
+	globalLeafCacheMu sync.RWMutex
+	// globalLeafCache is the process-wide leaf certificate cache used by
+	// CertForClient. SetupCA always replaces it via setGlobalLeafCacheSize,
+	// sized by CAConfig.LeafCacheSize (or the default when that is zero).
+	globalLeafCache = newLeafCache(defaultLeafCacheSize)
+)
+
+// setGlobalLeafCacheSize replaces globalLeafCache with one sized for size,
+// falling back to defaultLeafCacheSize when size is zero or negative.
+func setGlobalLeafCacheSize(size int) {
+	globalLeafCacheMu.Lock()
+	defer globalLeafCacheMu.Unlock()
+	globalLeafCache = newLeafCache(size)
+}
+
+func getGlobalLeafCache() *leafCache {
+	globalLeafCacheMu.RLock()
+	defer globalLeafCacheMu.RUnlock()
+	return globalLeafCache
+}
+
+func bucketBackdate(backdate time.Time) int64 {
+	if backdate.IsZero() {
+		return 0
+	}
+	return backdate.Unix() / int64((24 * time.Hour).Seconds())
+}
+
+// getOrSign returns a cached leaf certificate for sni/backdate if one
+// exists and hasn't expired, otherwise it signs a new one via sign,
+// ensuring concurrent callers for the same key only trigger one sign.
+func (c *leafCache) getOrSign(sni string, backdate time.Time, sign func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	key := leafCacheKey{sni: sni, backdateDay: bucketBackdate(backdate)}
+
+	if entry, ok := c.cache.Get(key); ok {
+		if time.Now().Before(entry.notAfter) {
+			return entry.cert, nil
+		}
+		c.cache.Remove(key)
+	}
+
+	groupKey := sni + "|" + strconv.FormatInt(key.backdateDay, 10)
+	v, err, _ := c.group.Do(groupKey, func() (any, error) {
+		cert, err := sign()
+		if err != nil {
+			return nil, err
+		}
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse freshly signed leaf for caching: %w", err)
+		}
+
+		c.cache.Add(key, leafCacheEntry{cert: cert, notAfter: leaf.NotAfter.Add(-time.Hour)})
+		return cert, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*tls.Certificate), nil
+}
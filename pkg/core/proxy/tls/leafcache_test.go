@@ -0,0 +1,140 @@
+//go:build linux
+
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// selfSignedLeaf mints a throwaway leaf certificate valid until notAfter, for
+// exercising leafCache without a real CFSSL sign.
+func selfSignedLeaf(t *testing.T, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate test key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "leafcache-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to self-sign test leaf: %v", err)
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestLeafCacheGetOrSignCacheHit(t *testing.T) {
+	c := newLeafCache(defaultLeafCacheSize)
+	var signs atomic.Int32
+	sign := func() (*tls.Certificate, error) {
+		signs.Add(1)
+		return selfSignedLeaf(t, time.Now().Add(24*time.Hour)), nil
+	}
+
+	first, err := c.getOrSign("stripe.com", time.Time{}, sign)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := c.getOrSign("stripe.com", time.Time{}, sign)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if signs.Load() != 1 {
+		t.Fatalf("expected sign to run once on a cache hit, ran %d times", signs.Load())
+	}
+	if first != second {
+		t.Fatalf("expected the cached certificate to be returned on the second call")
+	}
+}
+
+func TestLeafCacheGetOrSignExpiry(t *testing.T) {
+	c := newLeafCache(defaultLeafCacheSize)
+	var signs atomic.Int32
+
+	// The cache treats a leaf as stale an hour before its own NotAfter, so a
+	// leaf expiring imminently is already stale the moment it's cached.
+	sign := func() (*tls.Certificate, error) {
+		signs.Add(1)
+		return selfSignedLeaf(t, time.Now().Add(time.Minute)), nil
+	}
+
+	if _, err := c.getOrSign("stripe.com", time.Time{}, sign); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.getOrSign("stripe.com", time.Time{}, sign); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if signs.Load() != 2 {
+		t.Fatalf("expected the stale entry to trigger a re-sign, sign ran %d times", signs.Load())
+	}
+}
+
+func TestLeafCacheGetOrSignSingleflight(t *testing.T) {
+	c := newLeafCache(defaultLeafCacheSize)
+	var signs atomic.Int32
+	start := make(chan struct{})
+
+	sign := func() (*tls.Certificate, error) {
+		signs.Add(1)
+		<-start
+		return selfSignedLeaf(t, time.Now().Add(24*time.Hour)), nil
+	}
+
+	const concurrency = 16
+	var wg sync.WaitGroup
+	errs := make([]error, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := c.getOrSign("stripe.com", time.Time{}, sign)
+			errs[i] = err
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error from concurrent getOrSign: %v", err)
+		}
+	}
+	if signs.Load() != 1 {
+		t.Fatalf("expected singleflight to collapse concurrent callers into one sign, ran %d times", signs.Load())
+	}
+}
+
+func TestLeafCacheGetOrSignPropagatesSignError(t *testing.T) {
+	c := newLeafCache(defaultLeafCacheSize)
+	wantErr := errors.New("sign failed")
+	sign := func() (*tls.Certificate, error) {
+		return nil, wantErr
+	}
+
+	_, err := c.getOrSign("stripe.com", time.Time{}, sign)
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("expected getOrSign to propagate the sign error, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package trustinstaller
+
+import (
+	"context"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// envVarNames are every env var a runtime consults instead of (or in
+// addition to) the OS trust store: Node, Python, curl, Ruby, Deno, Go, and
+// .NET's SocketsHttpHandler.
+var envVarNames = []string{
+	"NODE_EXTRA_CA_CERTS",
+	"REQUESTS_CA_BUNDLE", // Python requests
+	"SSL_CERT_FILE",      // Go crypto/x509, Ruby OpenSSL
+	"CURL_CA_BUNDLE",
+	"DENO_CERT",
+}
+
+// EnvVar points every runtime that reads a CA bundle from an env var at
+// certPath. It can only affect this process and its children, so callers
+// still need to start the instrumented app after SetupCA runs.
+type EnvVar struct{}
+
+func (*EnvVar) Name() string { return "env-var" }
+
+func (e *EnvVar) Install(_ context.Context, logger *zap.Logger, certPath string) (*Change, error) {
+	var set []string
+	for _, name := range envVarNames {
+		if err := os.Setenv(name, certPath); err != nil {
+			logger.Warn("failed to set CA env var", zap.String("name", name), zap.Error(err))
+			continue
+		}
+		set = append(set, name)
+	}
+
+	// .NET's SocketsHttpHandler needs HTTP/2 support enabled to pick up a
+	// custom CA reliably; it doesn't take a bundle path itself.
+	if err := os.Setenv("DOTNET_SYSTEM_NET_HTTP_SOCKETSHTTPHANDLER_HTTP2SUPPORT", "1"); err == nil {
+		set = append(set, "DOTNET_SYSTEM_NET_HTTP_SOCKETSHTTPHANDLER_HTTP2SUPPORT")
+	}
+
+	if len(set) == 0 {
+		return nil, nil
+	}
+	return &Change{Installer: e.Name(), EnvVars: set}, nil
+}
+
+// Uninstall unsets every env var this installer set.
+func (e *EnvVar) Uninstall(_ context.Context, _ *zap.Logger, change Change) error {
+	for _, name := range change.EnvVars {
+		_ = os.Unsetenv(name)
+	}
+	return nil
+}
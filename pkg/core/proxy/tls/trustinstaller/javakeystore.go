@@ -0,0 +1,135 @@
+//go:build linux
+
+package trustinstaller
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	javaKeystorePass  = "changeit"
+	javaKeystoreAlias = "keployCA"
+)
+
+// JavaKeystore imports the CA into every JDK cacerts store it can find, not
+// just the one pointed at by JAVA_HOME -- covering GraalVM and machines
+// with multiple JDKs installed side by side.
+type JavaKeystore struct{}
+
+func (*JavaKeystore) Name() string { return "java-keystore" }
+
+func (j *JavaKeystore) Install(ctx context.Context, logger *zap.Logger, certPath string) (*Change, error) {
+	if _, err := exec.LookPath("keytool"); err != nil {
+		logger.Debug("keytool not found, skipping Java keystore install")
+		return nil, nil
+	}
+
+	cacertsPaths := discoverCacerts(ctx)
+	if len(cacertsPaths) == 0 {
+		logger.Debug("no Java installation detected, skipping Java keystore install")
+		return nil, nil
+	}
+
+	var touched []string
+	for _, cacerts := range cacertsPaths {
+		// Unconditionally replace any existing entry under our alias: an
+		// alias-presence check can't tell a stale CA (the user swapped
+		// --tls-ca-cert, or the per-installation CA was re-signed with
+		// widened constraints) from the current one, which would otherwise
+		// leave the old, now-unrelated cert trusted forever. A missing
+		// alias just makes -delete a no-op.
+		deleteCmd := exec.CommandContext(ctx, "keytool", "-delete", "-keystore", cacerts, "-storepass", javaKeystorePass, "-alias", javaKeystoreAlias)
+		_ = deleteCmd.Run()
+
+		cmd := exec.CommandContext(ctx, "keytool", "-import", "-trustcacerts", "-keystore", cacerts,
+			"-storepass", javaKeystorePass, "-noprompt", "-alias", javaKeystoreAlias, "-file", certPath)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			logger.Warn("failed to import CA into Java keystore", zap.String("cacerts", cacerts), zap.String("output", string(out)), zap.Error(err))
+			continue
+		}
+		touched = append(touched, cacerts+" "+javaKeystoreAlias)
+	}
+
+	if len(touched) == 0 {
+		return nil, nil
+	}
+	return &Change{Installer: j.Name(), Keystores: touched}, nil
+}
+
+// Uninstall removes the CA alias from every keystore this installer
+// imported it into.
+func (j *JavaKeystore) Uninstall(ctx context.Context, logger *zap.Logger, change Change) error {
+	var lastErr error
+	for _, keystore := range change.Keystores {
+		cacerts, alias, ok := strings.Cut(keystore, " ")
+		if !ok {
+			alias = javaKeystoreAlias
+		}
+		cmd := exec.CommandContext(ctx, "keytool", "-delete", "-keystore", cacerts, "-storepass", javaKeystorePass, "-alias", alias)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			logger.Warn("failed to remove CA from Java keystore", zap.String("cacerts", cacerts), zap.String("output", string(out)), zap.Error(err))
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// discoverCacerts finds cacerts files under JAVA_HOME (if set), every JDK
+// `java.home` reported by `java -XshowSettings:properties`, and common
+// install roots, so GraalVM and multi-JDK machines aren't left untrusted.
+func discoverCacerts(ctx context.Context) []string {
+	seen := make(map[string]struct{})
+	var paths []string
+
+	add := func(javaHome string) {
+		if javaHome == "" {
+			return
+		}
+		cacerts := filepath.Join(javaHome, "lib", "security", "cacerts")
+		if _, err := os.Stat(cacerts); err != nil {
+			return
+		}
+		if _, ok := seen[cacerts]; ok {
+			return
+		}
+		seen[cacerts] = struct{}{}
+		paths = append(paths, cacerts)
+	}
+
+	add(os.Getenv("JAVA_HOME"))
+
+	if out, err := exec.CommandContext(ctx, "java", "-XshowSettings:properties", "-version").CombinedOutput(); err == nil {
+		scanner := bufio.NewScanner(strings.NewReader(string(out)))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "java.home") {
+				_, value, ok := strings.Cut(line, "=")
+				if ok {
+					add(strings.TrimSpace(value))
+				}
+			}
+		}
+	}
+
+	for _, root := range []string{"/usr/lib/jvm", "/opt/graalvm", "/usr/java"} {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				add(filepath.Join(root, entry.Name()))
+			}
+		}
+	}
+
+	return paths
+}
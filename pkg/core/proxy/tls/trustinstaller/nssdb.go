@@ -0,0 +1,89 @@
+//go:build linux
+
+package trustinstaller
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+const nssCertNickname = "keployCA"
+
+// nssProfileGlobs are where Firefox and Chromium keep their per-profile NSS
+// databases on Linux; both also check ~/.pki/nssdb, the shared system-wide
+// one several distros point browsers at.
+var nssProfileGlobs = []string{
+	".mozilla/firefox/*.default*",
+	".mozilla/firefox/*.default-release*",
+	".config/google-chrome/*",
+	".config/chromium/*",
+	".pki/nssdb",
+}
+
+// NSSDB trusts the CA in every NSS certificate database it can find, since
+// Firefox and Chromium on Linux don't read the OS trust store directly.
+type NSSDB struct{}
+
+func (*NSSDB) Name() string { return "nssdb" }
+
+func (n *NSSDB) Install(ctx context.Context, logger *zap.Logger, certPath string) (*Change, error) {
+	if _, err := exec.LookPath("certutil"); err != nil {
+		logger.Debug("certutil not found, skipping NSS database install")
+		return nil, nil
+	}
+
+	var touched []string
+	for _, dir := range discoverNSSDBs() {
+		cmd := exec.CommandContext(ctx, "certutil", "-A", "-n", nssCertNickname, "-t", "C,,",
+			"-i", certPath, "-d", "sql:"+dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			logger.Warn("failed to import CA into NSS database", zap.String("dir", dir), zap.String("output", string(out)), zap.Error(err))
+			continue
+		}
+		touched = append(touched, dir)
+	}
+
+	if len(touched) == 0 {
+		return nil, nil
+	}
+	return &Change{Installer: n.Name(), NSSDBs: touched}, nil
+}
+
+// Uninstall removes the CA nickname from every NSS database this installer
+// imported it into.
+func (n *NSSDB) Uninstall(ctx context.Context, logger *zap.Logger, change Change) error {
+	var lastErr error
+	for _, dir := range change.NSSDBs {
+		cmd := exec.CommandContext(ctx, "certutil", "-D", "-n", nssCertNickname, "-d", "sql:"+dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			logger.Warn("failed to remove CA from NSS database", zap.String("dir", dir), zap.String("output", string(out)), zap.Error(err))
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func discoverNSSDBs() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, pattern := range nssProfileGlobs {
+		matches, err := filepath.Glob(filepath.Join(home, pattern))
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				dirs = append(dirs, match)
+			}
+		}
+	}
+	return dirs
+}
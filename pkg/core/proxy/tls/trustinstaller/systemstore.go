@@ -0,0 +1,103 @@
+//go:build linux
+
+package trustinstaller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+var systemStorePaths = []string{
+	"/usr/local/share/ca-certificates/",
+	"/etc/pki/ca-trust/source/anchors/",
+	"/etc/ca-certificates/trust-source/anchors/",
+	"/etc/pki/trust/anchors/",
+	"/usr/local/share/certs/",
+	"/etc/ssl/certs/",
+}
+
+var systemStoreUpdateCmds = []string{
+	"update-ca-certificates",
+	"update-ca-trust",
+	"trust extract-compat",
+	"tools-ca-trust extract",
+	"certctl rehash",
+}
+
+// SystemStore installs the CA into whichever OS trust anchor directories
+// exist on this machine and re-runs the matching update command.
+type SystemStore struct{}
+
+func (*SystemStore) Name() string { return "system-store" }
+
+func (s *SystemStore) Install(ctx context.Context, logger *zap.Logger, certPath string) (*Change, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate %s: %w", certPath, err)
+	}
+
+	var dirs []string
+	for _, dir := range systemStorePaths {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no valid CA store path found")
+	}
+
+	var written []string
+	for _, dir := range dirs {
+		dst := filepath.Join(dir, "ca.crt")
+		if err := os.WriteFile(dst, certPEM, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write CA certificate to %s: %w", dst, err)
+		}
+		written = append(written, dst)
+	}
+
+	if err := s.runUpdateCmd(ctx); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("installed CA in the system trust store", zap.Strings("paths", written))
+	return &Change{Installer: s.Name(), FilesWritten: written}, nil
+}
+
+func (s *SystemStore) runUpdateCmd(ctx context.Context) error {
+	ran := false
+	for _, cmd := range systemStoreUpdateCmds {
+		if _, err := exec.LookPath(cmd); err != nil {
+			continue
+		}
+		ran = true
+		c := exec.CommandContext(ctx, cmd)
+		if _, err := c.CombinedOutput(); err != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				return fmt.Errorf("failed to run %s: %w", cmd, err)
+			}
+		}
+	}
+	if !ran {
+		return fmt.Errorf("no valid CA store update command found")
+	}
+	return nil
+}
+
+// Uninstall deletes the ca.crt files this installer wrote and re-runs the
+// update command so the removal takes effect.
+func (s *SystemStore) Uninstall(ctx context.Context, logger *zap.Logger, change Change) error {
+	for _, path := range change.FilesWritten {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Warn("failed to remove CA certificate", zap.String("path", path), zap.Error(err))
+		}
+	}
+	return s.runUpdateCmd(ctx)
+}
@@ -0,0 +1,193 @@
+//go:build linux
+
+// Package trustinstaller trusts Keploy's CA certificate across the runtime
+// trust stores apps under test actually read from (the OS store, Java/other
+// keystores, NSS databases, and the env vars interpreters fall back to),
+// and records what it changed so it can be reversed later.
+package trustinstaller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Change records what a single Installer modified, so Uninstall can reverse
+// it precisely instead of re-deriving what "clean" looks like.
+type Change struct {
+	Installer    string   `json:"installer"`
+	FilesWritten []string `json:"filesWritten,omitempty"`
+	// Keystores holds "path alias" pairs imported into, e.g. a Java cacerts
+	// store, so they can be removed with `keytool -delete`.
+	Keystores []string `json:"keystores,omitempty"`
+	EnvVars   []string `json:"envVars,omitempty"`
+	NSSDBs    []string `json:"nssDbs,omitempty"`
+}
+
+// Installer trusts certPath in one runtime/store and reports exactly what
+// it changed.
+type Installer interface {
+	// Name identifies this installer in logs and in the persisted change-set.
+	Name() string
+	// Install trusts certPath. A nil Change means nothing was changed (e.g.
+	// the store's tooling isn't installed, or every import attempt failed).
+	Install(ctx context.Context, logger *zap.Logger, certPath string) (*Change, error)
+}
+
+// ChangeSet is everything a SetupCA install pass did, persisted so
+// `keploy cert uninstall` can reverse it without rediscovering it.
+type ChangeSet struct {
+	InstalledAt time.Time `json:"installedAt"`
+	Changes     []Change  `json:"changes"`
+}
+
+// DefaultInstallers is the set SetupCA iterates over, covering every
+// runtime observed to need its own trust configuration: the OS store, Java
+// (including non-JAVA_HOME JDKs), Firefox/Chromium's NSS databases, and the
+// env vars Node/Python/curl/Ruby/.NET/Deno/Go fall back to.
+func DefaultInstallers() []Installer {
+	return []Installer{
+		&SystemStore{},
+		&JavaKeystore{},
+		&EnvVar{},
+		&NSSDB{},
+	}
+}
+
+// Install runs every installer against certPath, logs and skips any that
+// fail rather than aborting the whole pass, and persists the combined
+// change-set to ~/.keploy/ca/installed.json for later uninstall.
+func Install(ctx context.Context, logger *zap.Logger, certPath string, installers []Installer) (*ChangeSet, error) {
+	set := &ChangeSet{InstalledAt: time.Now()}
+
+	for _, installer := range installers {
+		change, err := installer.Install(ctx, logger, certPath)
+		if err != nil {
+			logger.Warn("failed to install CA trust", zap.String("installer", installer.Name()), zap.Error(err))
+			continue
+		}
+		if change != nil {
+			set.Changes = append(set.Changes, *change)
+		}
+	}
+
+	if err := persist(set); err != nil {
+		return set, fmt.Errorf("failed to persist installed CA change-set: %w", err)
+	}
+
+	return set, nil
+}
+
+// Uninstall reverses every change recorded in ~/.keploy/ca/installed.json,
+// matching installers to their recorded changes by name.
+func Uninstall(ctx context.Context, logger *zap.Logger, installers []Installer) error {
+	set, err := load()
+	if err != nil {
+		return fmt.Errorf("failed to load installed CA change-set: %w", err)
+	}
+	if set == nil {
+		logger.Info("no recorded CA trust changes to uninstall")
+		return nil
+	}
+
+	byName := make(map[string]Installer, len(installers))
+	for _, installer := range installers {
+		byName[installer.Name()] = installer
+	}
+
+	var lastErr error
+	for _, change := range set.Changes {
+		remover, ok := byName[change.Installer].(Remover)
+		if !ok {
+			logger.Warn("no uninstall support for recorded change", zap.String("installer", change.Installer))
+			continue
+		}
+		if err := remover.Uninstall(ctx, logger, change); err != nil {
+			logger.Warn("failed to reverse CA trust change", zap.String("installer", change.Installer), zap.Error(err))
+			lastErr = err
+		}
+	}
+
+	if lastErr == nil {
+		if err := removeChangeSetFile(); err != nil {
+			logger.Warn("failed to remove installed.json after uninstall", zap.Error(err))
+		}
+	}
+
+	return lastErr
+}
+
+// Remover is implemented by installers that can reverse their own Change.
+// Not every Installer needs to implement it (e.g. one that only reports
+// what already existed has nothing to undo).
+type Remover interface {
+	Uninstall(ctx context.Context, logger *zap.Logger, change Change) error
+}
+
+func keployCADir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve home directory: %w", err)
+	}
+	dir := filepath.Join(home, ".keploy", "ca")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+func changeSetPath() (string, error) {
+	dir, err := keployCADir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "installed.json"), nil
+}
+
+func persist(set *ChangeSet) error {
+	path, err := changeSetPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func load() (*ChangeSet, error) {
+	path, err := changeSetPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var set ChangeSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &set, nil
+}
+
+func removeChangeSetFile() error {
+	path, err := changeSetPath()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}